@@ -1,22 +1,31 @@
 package commands
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
+	"sort"
 
 	"github.com/durp/reticule/pkg/coinbasepro"
+	"github.com/durp/reticule/pkg/secrets"
 	"github.com/spf13/afero"
 	"gopkg.in/yaml.v3"
 )
 
 type configCmd struct {
-	Create createConfigCmd `kong:"cmd,name='create',help='create a new config'"`
-	Delete deleteConfigCmd `kong:"cmd,name='delete',help='delete a config'"`
-	Update updateConfigCmd `kong:"cmd,name='update',help='update an existing config'"`
+	Create  createConfigCmd  `kong:"cmd,name='create',help='create a new config'"`
+	Delete  deleteConfigCmd  `kong:"cmd,name='delete',help='delete a config'"`
+	Update  updateConfigCmd  `kong:"cmd,name='update',help='update an existing config'"`
+	Use     useConfigCmd     `kong:"cmd,name='use',help='switch the current profile'"`
+	List    listConfigCmd    `kong:"cmd,name='list',help='list known profiles'"`
+	Show    showConfigCmd    `kong:"cmd,name='show',help='show a resolved profile, secrets redacted'"`
+	Export  exportConfigCmd  `kong:"cmd,name='export',help='export one or all profiles to an encrypted file'"`
+	Import  importConfigCmd  `kong:"cmd,name='import',help='import profiles from an encrypted export'"`
+	Restore restoreConfigCmd `kong:"cmd,name='restore',help='restore the config file from a backup generation'"`
 }
 
 type createConfigCmd struct {
@@ -32,77 +41,166 @@ type updateConfigCmd struct {
 }
 
 type reticuleConfigSet struct {
-	Current string
-	Configs map[string]reticuleConfig
+	Current           string
+	SecretsBackend    string `yaml:"secrets_backend,omitempty"`
+	BackupGenerations int    `yaml:"backup_generations,omitempty"`
+	Configs           map[string]reticuleConfig
 }
 
 type reticuleConfig struct {
-	BaseURL      string
-	FeedURL      string
-	Auth         *coinbasepro.Auth
-	ServerIP     string
-	ServerPort   int
-	ServerSecret string
+	BaseURL         string
+	FeedURL         string
+	AuthRef         string            `yaml:"auth_ref,omitempty"`
+	Auth            *coinbasepro.Auth `yaml:"-"`
+	ServerIP        string
+	ServerPort      int
+	ServerSecretRef string `yaml:"server_secret_ref,omitempty"`
+	ServerSecret    string `yaml:"-"`
+	// SecretsBackend is the backend this profile's secrets actually live
+	// in, independent of configSet.SecretsBackend, so profiles created
+	// with different --secrets-backend values stay resolvable.
+	SecretsBackend string `yaml:"secrets_backend,omitempty"`
+}
+
+// secretsBackendKeyring and secretsBackendFile are the supported values
+// for --secrets-backend and configSet.SecretsBackend.
+const (
+	secretsBackendKeyring = "keyring"
+	secretsBackendFile    = "file"
+)
+
+// authRef and serverSecretRef build the opaque secret refs stored in the
+// YAML config in place of plaintext credentials.
+func authRef(name string) string         { return fmt.Sprintf("reticule/%s/coinbase", name) }
+func serverSecretRef(name string) string { return fmt.Sprintf("reticule/%s/server", name) }
+
+// configSecretsBackend resolves which backend a profile's secrets live
+// in: the profile's own record first, then the config set's recorded
+// default, then the keyring for profiles written before this field
+// existed.
+func configSecretsBackend(cfg reticuleConfig, configSet reticuleConfigSet) string {
+	if cfg.SecretsBackend != "" {
+		return cfg.SecretsBackend
+	}
+	if configSet.SecretsBackend != "" {
+		return configSet.SecretsBackend
+	}
+	return secretsBackendKeyring
+}
+
+// secretsStore resolves a --secrets-backend value to a secrets.Store.
+func secretsStore(fs afero.Fs, backend string) (secrets.Store, error) {
+	switch backend {
+	case "", secretsBackendKeyring:
+		return secrets.NewKeyringStore(), nil
+	case secretsBackendFile:
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.New("no user home directory defined")
+		}
+		passphrase := os.Getenv("RETICULE_SECRETS_PASSPHRASE")
+		if passphrase == "" {
+			return nil, errors.New("RETICULE_SECRETS_PASSPHRASE must be set to use the file secrets backend")
+		}
+		return secrets.NewFileStore(fs, path.Join(home, ".reticule", "secrets"), []byte(passphrase))
+	default:
+		return nil, fmt.Errorf("unknown secrets backend %q", backend)
+	}
+}
+
+// putAuth stores auth as JSON in store under ref, overwriting any value
+// already stored there.
+func putAuth(store secrets.Store, ref string, auth *coinbasepro.Auth) error {
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	return store.Set(ref, b)
+}
+
+// getAuth resolves ref to coinbasepro credentials via store.
+func getAuth(store secrets.Store, ref string) (*coinbasepro.Auth, error) {
+	b, err := store.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	var auth coinbasepro.Auth
+	if err := json.Unmarshal(b, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
 }
 
 type createReticuleConfigCmd struct {
-	Name        string   `kong:"name='name',short='n',help='name of config',required"`
-	BaseURL     *url.URL `kong:"name='base-url',short='b',default='https://api-public.sandbox.pro.coinbase.com',help='url of coinbasepro api that provided key'"`
-	FeedURL     *url.URL `kong:"name='feed-url',short='f',default='wss://ws-feed-public.sandbox.pro.coinbase.com',help='url of websocket feed'"`
-	Key         string   `kong:"name='key',short='k',help='coinbasepro provided api key'"`
-	Passphrase  string   `kong:"name='passphrase',short='p',help='coinbasepro api passphrase'"`
-	Secret      string   `kong:"name='secret',short='s',help='coinbasepro provided api secret'"`
-	Use         bool     `kong:"name='use',short='u',help='set as config to use'"`
-	ServerPort  int      `kong:"name='port',short='t',default='80',help='port to use in server mode'"`
-	BindAddress string   `kong:"name='bind-address',short='l',default='127.0.0.1',help='ip address to use in server mode'"`
-	ServerAuth  string   `kong:"name='server-auth',short='a',default='default',help='Pre-shared secret for auth in server mode'"`
-}
-
-func (c *createReticuleConfigCmd) Run(fs afero.Fs) (capture error) {
+	Name           string   `kong:"name='name',short='n',help='name of config',required"`
+	BaseURL        *url.URL `kong:"name='base-url',short='b',default='https://api-public.sandbox.pro.coinbase.com',help='url of coinbasepro api that provided key'"`
+	FeedURL        *url.URL `kong:"name='feed-url',short='f',default='wss://ws-feed-public.sandbox.pro.coinbase.com',help='url of websocket feed'"`
+	Key            string   `kong:"name='key',short='k',help='coinbasepro provided api key'"`
+	Passphrase     string   `kong:"name='passphrase',short='p',help='coinbasepro api passphrase'"`
+	Secret         string   `kong:"name='secret',short='s',help='coinbasepro provided api secret'"`
+	Use            bool     `kong:"name='use',short='u',help='set as config to use'"`
+	ServerPort     int      `kong:"name='port',short='t',default='80',help='port to use in server mode'"`
+	BindAddress    string   `kong:"name='bind-address',short='l',default='127.0.0.1',help='ip address to use in server mode'"`
+	ServerAuth     string   `kong:"name='server-auth',short='a',default='default',help='Pre-shared secret for auth in server mode'"`
+	SecretsBackend string   `kong:"name='secrets-backend',default='keyring',enum='keyring,file',help='where to store api secrets: keyring or file'"`
+	PromptSecrets  bool     `kong:"name='prompt-secrets',help='prompt for key/passphrase/secret even if a flag was given'"`
+	KeyFile        string   `kong:"name='key-file',help='read the coinbasepro api key from this file instead of --key'"`
+	PassphraseFile string   `kong:"name='passphrase-file',help='read the coinbasepro api passphrase from this file instead of --passphrase'"`
+	SecretFile     string   `kong:"name='secret-file',help='read the coinbasepro api secret from this file instead of --secret'"`
+	Backups        int      `kong:"name='backups',default='3',help='how many rotated backups of the config file to keep'"`
+}
+
+func (c *createReticuleConfigCmd) Run(fs afero.Fs) error {
 	configPath, err := configPath()
 	if err != nil {
 		return err
 	}
 	var configSet reticuleConfigSet
-	f, err := fs.OpenFile(configPath, os.O_RDWR|os.O_CREATE, 0755)
-	switch {
-	case errors.Is(err, os.ErrNotExist):
-		cfgDir := path.Dir(configPath)
-		err = fs.MkdirAll(cfgDir, os.ModePerm)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("creating config %q\n", configPath)
-		f, err = fs.Create(configPath)
-		if err != nil {
-			return err
-		}
-	case err != nil:
+	exists, err := afero.Exists(fs, configPath)
+	if err != nil {
 		return err
-	default:
-		defer func() { coinbasepro.Capture(&capture, f.Close()) }()
-		b, err := ioutil.ReadAll(f)
-		if err != nil {
-			return err
-		}
-		err = yaml.Unmarshal(b, &configSet)
+	}
+	if exists {
+		configSet, err = readConfigSet(fs, configPath)
 		if err != nil {
 			return err
 		}
 		if _, ok := configSet.Configs[c.Name]; ok {
 			return fmt.Errorf("reticule config %q already exists, use `config update reticule` to modify an existing config", c.Name)
 		}
+	} else {
+		fmt.Printf("creating config %q\n", configPath)
+	}
+	store, err := secretsStore(fs, c.SecretsBackend)
+	if err != nil {
+		return err
+	}
+	key, err := resolveSecret(fs, "coinbasepro api key", c.Key, c.KeyFile, c.PromptSecrets, true, true)
+	if err != nil {
+		return err
+	}
+	passphrase, err := resolveSecret(fs, "coinbasepro api passphrase", c.Passphrase, c.PassphraseFile, c.PromptSecrets, true, true)
+	if err != nil {
+		return err
+	}
+	secret, err := resolveSecret(fs, "coinbasepro api secret", c.Secret, c.SecretFile, c.PromptSecrets, true, true)
+	if err != nil {
+		return err
 	}
 	cfg := reticuleConfig{
-		BaseURL: c.BaseURL.String(),
-		FeedURL: c.FeedURL.String(),
-		Auth: coinbasepro.NewAuth(
-			c.Key,
-			c.Passphrase,
-			c.Secret),
-		ServerIP:     c.BindAddress,
-		ServerPort:   c.ServerPort,
-		ServerSecret: c.ServerAuth,
+		BaseURL:         c.BaseURL.String(),
+		FeedURL:         c.FeedURL.String(),
+		AuthRef:         authRef(c.Name),
+		ServerIP:        c.BindAddress,
+		ServerPort:      c.ServerPort,
+		ServerSecretRef: serverSecretRef(c.Name),
+		SecretsBackend:  c.SecretsBackend,
+	}
+	if err := putAuth(store, cfg.AuthRef, coinbasepro.NewAuth(key, passphrase, secret)); err != nil {
+		return err
+	}
+	if err := store.Set(cfg.ServerSecretRef, []byte(c.ServerAuth)); err != nil {
+		return err
 	}
 	if configSet.Configs == nil {
 		configSet.Configs = make(map[string]reticuleConfig)
@@ -111,13 +209,14 @@ func (c *createReticuleConfigCmd) Run(fs afero.Fs) (capture error) {
 	if c.Use {
 		configSet.Current = c.Name
 	}
-	configSet.Configs[c.Name] = cfg
-	enc := yaml.NewEncoder(f)
-	err = enc.Encode(&configSet)
-	if err != nil {
-		return err
+	if configSet.SecretsBackend == "" {
+		configSet.SecretsBackend = c.SecretsBackend
 	}
-	return enc.Close()
+	if configSet.BackupGenerations == 0 {
+		configSet.BackupGenerations = c.Backups
+	}
+	configSet.Configs[c.Name] = cfg
+	return writeConfigSet(fs, configPath, configSet)
 }
 
 type deleteReticuleConfigCmd struct {
@@ -133,22 +232,40 @@ func (d *deleteReticuleConfigCmd) Run(fs afero.Fs) error {
 	if err != nil {
 		return err
 	}
+	if cfg, ok := configSet.Configs[d.Name]; ok {
+		store, err := secretsStore(fs, configSecretsBackend(cfg, configSet))
+		if err != nil {
+			return err
+		}
+		if err := store.Delete(cfg.AuthRef); err != nil {
+			return err
+		}
+		if err := store.Delete(cfg.ServerSecretRef); err != nil {
+			return err
+		}
+	}
 	delete(configSet.Configs, d.Name)
 	return writeConfigSet(fs, configPath, configSet)
 }
 
 type updateReticuleConfigCmd struct {
-	Name        string   `kong:"name='name',short='n',help='name of config',required"`
-	BaseURL     *url.URL `kong:"name='base-url',short='b',help='url of coinbasepro api that provided key'"`
-	FeedURL     *url.URL `kong:"name='feed-url',short='f',help='url of websocket feed'"`
-	Key         string   `kong:"name='key',short='k',help='coinbasepro provided api key'"`
-	Passphrase  string   `kong:"name='passphrase',short='p',help='coinbasepro api passphrase'"`
-	Rename      string   `kong:"name='rename',short='r',help='new name for config'"`
-	Secret      string   `kong:"name='secret',short='s',help='coinbasepro provided api secret'"`
-	Use         bool     `kong:"name='use',short='s',help='set as config to use'"`
-	ServerPort  int      `kong:"name='port',short='t',help='port to use in server mode'"`
-	BindAddress string   `kong:"name='bind-address',short='l',help='ip address to use in server mode'"`
-	ServerAuth  string   `kong:"name='server-auth',short='a',help='Pre-shared secret for auth in server mode'"`
+	Name           string   `kong:"name='name',short='n',help='name of config',required"`
+	BaseURL        *url.URL `kong:"name='base-url',short='b',help='url of coinbasepro api that provided key'"`
+	FeedURL        *url.URL `kong:"name='feed-url',short='f',help='url of websocket feed'"`
+	Key            string   `kong:"name='key',short='k',help='coinbasepro provided api key'"`
+	Passphrase     string   `kong:"name='passphrase',short='p',help='coinbasepro api passphrase'"`
+	Rename         string   `kong:"name='rename',short='r',help='new name for config'"`
+	Secret         string   `kong:"name='secret',short='s',help='coinbasepro provided api secret'"`
+	Use            bool     `kong:"name='use',short='s',help='set as config to use'"`
+	ServerPort     int      `kong:"name='port',short='t',help='port to use in server mode'"`
+	BindAddress    string   `kong:"name='bind-address',short='l',help='ip address to use in server mode'"`
+	ServerAuth     string   `kong:"name='server-auth',short='a',help='Pre-shared secret for auth in server mode'"`
+	SecretsBackend string   `kong:"name='secrets-backend',enum='keyring,file',help='where to store api secrets: keyring or file; defaults to the profile''s existing backend'"`
+	PromptSecrets  bool     `kong:"name='prompt-secrets',help='prompt for key/passphrase/secret even if a flag was given'"`
+	KeyFile        string   `kong:"name='key-file',help='read the coinbasepro api key from this file instead of --key'"`
+	PassphraseFile string   `kong:"name='passphrase-file',help='read the coinbasepro api passphrase from this file instead of --passphrase'"`
+	SecretFile     string   `kong:"name='secret-file',help='read the coinbasepro api secret from this file instead of --secret'"`
+	Backups        int      `kong:"name='backups',help='how many rotated backups of the config file to keep'"`
 }
 
 func (c *updateReticuleConfigCmd) Run(fs afero.Fs) error {
@@ -164,27 +281,97 @@ func (c *updateReticuleConfigCmd) Run(fs afero.Fs) error {
 	if !ok {
 		return fmt.Errorf("reticule config %q does not exists, use `config create reticule` to create a new config", c.Name)
 	}
+	oldBackend := configSecretsBackend(cfg, configSet)
+	newBackend := c.SecretsBackend
+	if newBackend == "" {
+		newBackend = oldBackend
+	}
+	oldStore, err := secretsStore(fs, oldBackend)
+	if err != nil {
+		return err
+	}
+	newStore := oldStore
+	if newBackend != oldBackend {
+		newStore, err = secretsStore(fs, newBackend)
+		if err != nil {
+			return err
+		}
+	}
+	// Read the current secrets from their existing backend/refs before
+	// anything about the profile's name or backend changes below.
+	auth, err := getAuth(oldStore, cfg.AuthRef)
+	if err != nil {
+		return err
+	}
+	serverSecret, err := oldStore.Get(cfg.ServerSecretRef)
+	if err != nil {
+		return err
+	}
 	if c.BaseURL != nil {
 		cfg.BaseURL = c.BaseURL.String()
 	}
 	if c.FeedURL != nil {
 		cfg.FeedURL = c.FeedURL.String()
 	}
-	if c.Key != "" {
-		cfg.Auth.Key = c.Key
+	key, err := resolveSecret(fs, "coinbasepro api key", c.Key, c.KeyFile, c.PromptSecrets, false, false)
+	if err != nil {
+		return err
+	}
+	passphrase, err := resolveSecret(fs, "coinbasepro api passphrase", c.Passphrase, c.PassphraseFile, c.PromptSecrets, false, false)
+	if err != nil {
+		return err
+	}
+	secret, err := resolveSecret(fs, "coinbasepro api secret", c.Secret, c.SecretFile, c.PromptSecrets, false, false)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		auth.Key = key
+	}
+	if passphrase != "" {
+		auth.Passphrase = passphrase
+	}
+	if secret != "" {
+		auth.Secret = secret
+	}
+	if c.ServerAuth != "" {
+		serverSecret = []byte(c.ServerAuth)
+	}
+
+	oldName := c.Name
+	newName := c.Name
+	if c.Rename != "" {
+		newName = c.Rename
+	}
+	newAuthRef := authRef(newName)
+	newServerSecretRef := serverSecretRef(newName)
+
+	if err := putAuth(newStore, newAuthRef, auth); err != nil {
+		return err
 	}
-	if c.Passphrase != "" {
-		cfg.Auth.Passphrase = c.Passphrase
+	if err := newStore.Set(newServerSecretRef, serverSecret); err != nil {
+		return err
 	}
-	if c.Secret != "" {
-		cfg.Auth.Secret = c.Secret
+	// The old refs are now stranded if the backend changed or the
+	// profile was renamed; clean them up so secrets don't leak or go
+	// unresolvable under a name nothing points to anymore.
+	if newBackend != oldBackend || newAuthRef != cfg.AuthRef {
+		if err := oldStore.Delete(cfg.AuthRef); err != nil {
+			return err
+		}
+		if err := oldStore.Delete(cfg.ServerSecretRef); err != nil {
+			return err
+		}
 	}
+
 	if c.Rename != "" {
-		delete(configSet.Configs, c.Name)
-		c.Name = c.Rename
+		delete(configSet.Configs, oldName)
 	}
+	cfg.AuthRef = newAuthRef
+	cfg.ServerSecretRef = newServerSecretRef
+	cfg.SecretsBackend = newBackend
 	if c.Use {
-		configSet.Current = c.Name
+		configSet.Current = newName
 	}
 	if c.BindAddress != "" {
 		cfg.ServerIP = c.BindAddress
@@ -192,13 +379,213 @@ func (c *updateReticuleConfigCmd) Run(fs afero.Fs) error {
 	if c.ServerPort != 0 {
 		cfg.ServerPort = c.ServerPort
 	}
-	if c.ServerAuth != "" {
-		cfg.ServerSecret = c.ServerAuth
+	if c.Backups != 0 {
+		configSet.BackupGenerations = c.Backups
 	}
-	configSet.Configs[c.Name] = cfg
+	configSet.Configs[newName] = cfg
 	return writeConfigSet(fs, configPath, configSet)
 }
 
+type useConfigCmd struct {
+	Name string `kong:"arg,name='name',help='name of config to make current'"`
+}
+
+func (c *useConfigCmd) Run(fs afero.Fs) error {
+	configPath, err := configPath()
+	if err != nil {
+		return err
+	}
+	configSet, err := readConfigSet(fs, configPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := configSet.Configs[c.Name]; !ok {
+		return fmt.Errorf("reticule config %q does not exist", c.Name)
+	}
+	configSet.Current = c.Name
+	return writeConfigSet(fs, configPath, configSet)
+}
+
+type listConfigCmd struct{}
+
+func (c *listConfigCmd) Run(fs afero.Fs) error {
+	configPath, err := configPath()
+	if err != nil {
+		return err
+	}
+	configSet, err := readConfigSet(fs, configPath)
+	if err != nil {
+		return err
+	}
+	current := resolveCurrentName(configSet)
+	names := make([]string, 0, len(configSet.Configs))
+	for name := range configSet.Configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == current {
+			fmt.Printf("* %s\n", name)
+			continue
+		}
+		fmt.Printf("  %s\n", name)
+	}
+	return nil
+}
+
+type showConfigCmd struct {
+	Name string `kong:"arg,name='name',optional,help='name of config to show, defaults to the current profile'"`
+}
+
+// resolvedConfigView is what `config show` prints: the resolved profile
+// with every secret field redacted.
+type resolvedConfigView struct {
+	Name         string
+	BaseURL      string
+	FeedURL      string
+	Key          string
+	Passphrase   string
+	Secret       string
+	ServerIP     string
+	ServerPort   int
+	ServerSecret string
+}
+
+func (c *showConfigCmd) Run(fs afero.Fs) error {
+	configPath, err := configPath()
+	if err != nil {
+		return err
+	}
+	configSet, err := readConfigSet(fs, configPath)
+	if err != nil {
+		return err
+	}
+	profile, err := loadResolvedProfile(fs, configSet, c.Name)
+	if err != nil {
+		return err
+	}
+	view := resolvedConfigView{
+		Name:         profile.Name,
+		BaseURL:      profile.BaseURL,
+		FeedURL:      profile.FeedURL,
+		Key:          redact(profile.Auth.Key),
+		Passphrase:   redact(profile.Auth.Passphrase),
+		Secret:       redact(profile.Auth.Secret),
+		ServerIP:     profile.ServerIP,
+		ServerPort:   profile.ServerPort,
+		ServerSecret: redact(string(profile.ServerSecret)),
+	}
+	enc := yaml.NewEncoder(os.Stdout)
+	if err := enc.Encode(&view); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "****"
+}
+
+// resolveCurrentName returns the profile name to operate on, honoring
+// RETICULE_PROFILE over configSet.Current so CI can select a profile
+// without mutating the config file on disk.
+func resolveCurrentName(configSet reticuleConfigSet) string {
+	if v := os.Getenv("RETICULE_PROFILE"); v != "" {
+		return v
+	}
+	return configSet.Current
+}
+
+// overrideConfigFields layers non-secret env var overrides over cfg at
+// load time, similar to how viper-based CLIs prefer env vars over file
+// config.
+func overrideConfigFields(cfg reticuleConfig) reticuleConfig {
+	if v := os.Getenv("RETICULE_BASE_URL"); v != "" {
+		cfg.BaseURL = v
+	}
+	if v := os.Getenv("RETICULE_FEED_URL"); v != "" {
+		cfg.FeedURL = v
+	}
+	if v := os.Getenv("RETICULE_BIND_ADDRESS"); v != "" {
+		cfg.ServerIP = v
+	}
+	return cfg
+}
+
+// overrideAuthFields layers RETICULE_KEY/RETICULE_PASSPHRASE/RETICULE_SECRET
+// over an already-resolved coinbasepro.Auth.
+func overrideAuthFields(auth *coinbasepro.Auth) {
+	if v := os.Getenv("RETICULE_KEY"); v != "" {
+		auth.Key = v
+	}
+	if v := os.Getenv("RETICULE_PASSPHRASE"); v != "" {
+		auth.Passphrase = v
+	}
+	if v := os.Getenv("RETICULE_SECRET"); v != "" {
+		auth.Secret = v
+	}
+}
+
+// resolvedProfile is a profile fully resolved for use: secrets fetched
+// from its backend with RETICULE_* env var overrides layered on top, so
+// it's ready for any command that actually connects with it, not just
+// one that displays it.
+type resolvedProfile struct {
+	Name         string
+	BaseURL      string
+	FeedURL      string
+	Auth         *coinbasepro.Auth
+	ServerIP     string
+	ServerPort   int
+	ServerSecret []byte
+}
+
+// loadResolvedProfile is the shared load path for any command that
+// actually uses a profile to do work: it resolves name (falling back to
+// resolveCurrentName when empty), fetches its secrets, and layers the
+// RETICULE_* env var overrides on top so profiles can be safely
+// overridden in CI without touching the config file. `config show` is
+// just one caller of this; every command that loads a profile to act on
+// it should go through here too.
+func loadResolvedProfile(fs afero.Fs, configSet reticuleConfigSet, name string) (resolvedProfile, error) {
+	if name == "" {
+		name = resolveCurrentName(configSet)
+	}
+	cfg, ok := configSet.Configs[name]
+	if !ok {
+		return resolvedProfile{}, fmt.Errorf("reticule config %q does not exist", name)
+	}
+	cfg = overrideConfigFields(cfg)
+	store, err := secretsStore(fs, configSecretsBackend(cfg, configSet))
+	if err != nil {
+		return resolvedProfile{}, err
+	}
+	auth, err := getAuth(store, cfg.AuthRef)
+	if err != nil {
+		return resolvedProfile{}, err
+	}
+	overrideAuthFields(auth)
+	serverSecret, err := store.Get(cfg.ServerSecretRef)
+	if err != nil {
+		return resolvedProfile{}, err
+	}
+	if v := os.Getenv("RETICULE_SERVER_SECRET"); v != "" {
+		serverSecret = []byte(v)
+	}
+	return resolvedProfile{
+		Name:         name,
+		BaseURL:      cfg.BaseURL,
+		FeedURL:      cfg.FeedURL,
+		Auth:         auth,
+		ServerIP:     cfg.ServerIP,
+		ServerPort:   cfg.ServerPort,
+		ServerSecret: serverSecret,
+	}, nil
+}
+
 func configPath() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -229,16 +616,109 @@ func readConfigSet(fs afero.Fs, configPath string) (reticuleConfigSet, error) {
 	}
 }
 
+// defaultConfigBackups is how many prior versions of the config file
+// writeConfigSet keeps around (reticule.bak, reticule.bak.1, ...).
+const defaultConfigBackups = 3
+
+// writeConfigSet writes configSet to configPath atomically: it encodes to
+// a sibling temp file with owner-only permissions, fsyncs it, backs up
+// the previous file, and only then renames the temp file into place. A
+// crash at any point leaves either the old file or the new one intact,
+// never a truncated one.
 func writeConfigSet(fs afero.Fs, configPath string, configSet reticuleConfigSet) (capture error) {
-	f, err := fs.OpenFile(configPath, os.O_WRONLY|os.O_TRUNC, 0755)
+	if err := fs.MkdirAll(path.Dir(configPath), 0700); err != nil {
+		return err
+	}
+	tmpPath := fmt.Sprintf("%s.tmp-%d", configPath, os.Getpid())
+	f, err := fs.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 	defer func() { coinbasepro.Capture(&capture, f.Close()) }()
 	enc := yaml.NewEncoder(f)
-	err = enc.Encode(&configSet)
+	if err := enc.Encode(&configSet); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	keep := configSet.BackupGenerations
+	if keep <= 0 {
+		keep = defaultConfigBackups
+	}
+	if err := rotateConfigBackups(fs, configPath, keep); err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, configPath)
+}
+
+// backupPath returns the path of the gen'th backup of configPath, where
+// gen 0 is the most recent (reticule.bak) and higher numbers are older
+// (reticule.bak.1, reticule.bak.2, ...).
+func backupPath(configPath string, gen int) string {
+	if gen == 0 {
+		return configPath + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", configPath, gen)
+}
+
+// rotateConfigBackups copies the current configPath (if any) to
+// backupPath(configPath, 0), first shifting older backups down a
+// generation and dropping anything past the keep'th generation.
+func rotateConfigBackups(fs afero.Fs, configPath string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	exists, err := afero.Exists(fs, configPath)
 	if err != nil {
 		return err
 	}
-	return enc.Close()
+	if !exists {
+		return nil
+	}
+	if err := fs.Remove(backupPath(configPath, keep-1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for gen := keep - 2; gen >= 0; gen-- {
+		err := fs.Rename(backupPath(configPath, gen), backupPath(configPath, gen+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	b, err := afero.ReadFile(fs, configPath)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, backupPath(configPath, 0), b, 0600)
+}
+
+type restoreConfigCmd struct {
+	Generation int `kong:"name='generation',default='0',help='backup generation to restore, 0 is the most recent'"`
+}
+
+func (c *restoreConfigCmd) Run(fs afero.Fs) error {
+	configPath, err := configPath()
+	if err != nil {
+		return err
+	}
+	bp := backupPath(configPath, c.Generation)
+	exists, err := afero.Exists(fs, bp)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("no config backup at generation %d (%s)", c.Generation, bp)
+	}
+	b, err := afero.ReadFile(fs, bp)
+	if err != nil {
+		return err
+	}
+	tmpPath := fmt.Sprintf("%s.tmp-%d", configPath, os.Getpid())
+	if err := afero.WriteFile(fs, tmpPath, b, 0600); err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, configPath)
 }
@@ -0,0 +1,285 @@
+package commands
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/durp/reticule/pkg/coinbasepro"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// scrypt/secretbox parameters for the export envelope. Kept separate from
+// pkg/secrets' file backend since the envelope is a one-shot transport
+// format, not a long-lived store.
+const (
+	exportVersion  = 1
+	exportKDF      = "scrypt"
+	exportScryptN  = 1 << 15
+	exportScryptR  = 8
+	exportScryptP  = 1
+	exportKeySize  = 32
+	exportSaltSize = 16
+)
+
+// configEnvelope is the self-contained encrypted transport format written
+// by `config export` and read by `config import`: a small header plus a
+// secretbox ciphertext of the YAML payload.
+type configEnvelope struct {
+	Version    int
+	KDF        string
+	Salt       []byte
+	Nonce      [24]byte
+	Ciphertext []byte
+}
+
+// exportedConfig carries a profile's resolved secrets (not refs) so the
+// envelope is self-contained on the receiving host.
+type exportedConfig struct {
+	BaseURL        string
+	FeedURL        string
+	Key            string
+	Passphrase     string
+	Secret         string
+	ServerIP       string
+	ServerPort     int
+	ServerSecret   string
+	SecretsBackend string
+}
+
+type exportedConfigSet struct {
+	Current string
+	Configs map[string]exportedConfig
+}
+
+type exportConfigCmd struct {
+	Name string `kong:"name='name',help='name of a single profile to export'"`
+	All  bool   `kong:"name='all',help='export every profile in the config set'"`
+	Out  string `kong:"name='out',required,help='file to write the encrypted export to'"`
+}
+
+func (c *exportConfigCmd) Run(fs afero.Fs) error {
+	if !c.All && c.Name == "" {
+		return errors.New("specify --name <profile> or --all")
+	}
+	cfgPath, err := configPath()
+	if err != nil {
+		return err
+	}
+	configSet, err := readConfigSet(fs, cfgPath)
+	if err != nil {
+		return err
+	}
+	var names []string
+	if c.All {
+		for name := range configSet.Configs {
+			names = append(names, name)
+		}
+	} else {
+		names = []string{c.Name}
+	}
+	exported := exportedConfigSet{Current: configSet.Current, Configs: make(map[string]exportedConfig)}
+	for _, name := range names {
+		cfg, ok := configSet.Configs[name]
+		if !ok {
+			return fmt.Errorf("reticule config %q does not exist", name)
+		}
+		backend := configSecretsBackend(cfg, configSet)
+		store, err := secretsStore(fs, backend)
+		if err != nil {
+			return err
+		}
+		auth, err := getAuth(store, cfg.AuthRef)
+		if err != nil {
+			return err
+		}
+		serverSecret, err := store.Get(cfg.ServerSecretRef)
+		if err != nil {
+			return err
+		}
+		exported.Configs[name] = exportedConfig{
+			BaseURL:        cfg.BaseURL,
+			FeedURL:        cfg.FeedURL,
+			Key:            auth.Key,
+			Passphrase:     auth.Passphrase,
+			Secret:         auth.Secret,
+			ServerIP:       cfg.ServerIP,
+			ServerPort:     cfg.ServerPort,
+			ServerSecret:   string(serverSecret),
+			SecretsBackend: backend,
+		}
+	}
+	payload, err := yaml.Marshal(&exported)
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase("export passphrase: ")
+	if err != nil {
+		return err
+	}
+	confirm, err := readPassphrase("confirm export passphrase: ")
+	if err != nil {
+		return err
+	}
+	if string(passphrase) != string(confirm) {
+		return errors.New("passphrases did not match")
+	}
+	env, err := sealEnvelope(payload, passphrase)
+	if err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(c.Out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	enc := yaml.NewEncoder(f)
+	if err := enc.Encode(env); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type importConfigCmd struct {
+	In             string `kong:"name='in',required,help='encrypted export file to read'"`
+	Overwrite      bool   `kong:"name='overwrite',help='replace profiles that already exist by that name'"`
+	SecretsBackend string `kong:"name='secrets-backend',default='keyring',enum='keyring,file',help='where to store imported api secrets: keyring or file'"`
+}
+
+func (c *importConfigCmd) Run(fs afero.Fs) error {
+	f, err := fs.Open(c.In)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	var env configEnvelope
+	if err := yaml.Unmarshal(b, &env); err != nil {
+		return err
+	}
+	if env.Version != exportVersion {
+		return fmt.Errorf("unsupported export version %d", env.Version)
+	}
+	if env.KDF != exportKDF {
+		return fmt.Errorf("unsupported kdf %q", env.KDF)
+	}
+	passphrase, err := readPassphrase("import passphrase: ")
+	if err != nil {
+		return err
+	}
+	payload, err := openEnvelope(env, passphrase)
+	if err != nil {
+		return err
+	}
+	var exported exportedConfigSet
+	if err := yaml.Unmarshal(payload, &exported); err != nil {
+		return err
+	}
+
+	cfgPath, err := configPath()
+	if err != nil {
+		return err
+	}
+	var configSet reticuleConfigSet
+	exists, err := afero.Exists(fs, cfgPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		configSet, err = readConfigSet(fs, cfgPath)
+		if err != nil {
+			return err
+		}
+	}
+	if configSet.Configs == nil {
+		configSet.Configs = make(map[string]reticuleConfig)
+	}
+	// Validate every collision up front. Map iteration order is random,
+	// so checking this inside the write loop below would make a
+	// collision abort mid-import after some profiles' secrets were
+	// already written to the store but before the YAML was updated.
+	for name := range exported.Configs {
+		if _, exists := configSet.Configs[name]; exists && !c.Overwrite {
+			return fmt.Errorf("reticule config %q already exists, pass --overwrite to replace it", name)
+		}
+	}
+	for name, ec := range exported.Configs {
+		backend := ec.SecretsBackend
+		if backend == "" {
+			backend = c.SecretsBackend
+		}
+		store, err := secretsStore(fs, backend)
+		if err != nil {
+			return err
+		}
+		cfg := reticuleConfig{
+			BaseURL:         ec.BaseURL,
+			FeedURL:         ec.FeedURL,
+			AuthRef:         authRef(name),
+			ServerIP:        ec.ServerIP,
+			ServerPort:      ec.ServerPort,
+			ServerSecretRef: serverSecretRef(name),
+			SecretsBackend:  backend,
+		}
+		if err := putAuth(store, cfg.AuthRef, coinbasepro.NewAuth(ec.Key, ec.Passphrase, ec.Secret)); err != nil {
+			return err
+		}
+		if err := store.Set(cfg.ServerSecretRef, []byte(ec.ServerSecret)); err != nil {
+			return err
+		}
+		configSet.Configs[name] = cfg
+	}
+	if configSet.Current == "" {
+		configSet.Current = exported.Current
+	}
+	if configSet.SecretsBackend == "" {
+		configSet.SecretsBackend = c.SecretsBackend
+	}
+	return writeConfigSet(fs, cfgPath, configSet)
+}
+
+func sealEnvelope(payload, passphrase []byte) (*configEnvelope, error) {
+	salt := make([]byte, exportSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, salt, exportScryptN, exportScryptR, exportScryptP, exportKeySize)
+	if err != nil {
+		return nil, err
+	}
+	var keyArr [32]byte
+	copy(keyArr[:], key)
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return &configEnvelope{
+		Version:    exportVersion,
+		KDF:        exportKDF,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: secretbox.Seal(nil, payload, &nonce, &keyArr),
+	}, nil
+}
+
+func openEnvelope(env configEnvelope, passphrase []byte) ([]byte, error) {
+	key, err := scrypt.Key(passphrase, env.Salt, exportScryptN, exportScryptR, exportScryptP, exportKeySize)
+	if err != nil {
+		return nil, err
+	}
+	var keyArr [32]byte
+	copy(keyArr[:], key)
+	plain, ok := secretbox.Open(nil, env.Ciphertext, &env.Nonce, &keyArr)
+	if !ok {
+		return nil, errors.New("failed to decrypt export, wrong passphrase?")
+	}
+	return plain, nil
+}
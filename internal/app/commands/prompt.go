@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/afero"
+	"golang.org/x/term"
+)
+
+// readPassphrase prompts on stderr and reads a line from stdin with echo
+// disabled, failing if stdin isn't a terminal so secrets never end up
+// silently read from a pipe or argv.
+func readPassphrase(prompt string) ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("stdin is not a terminal, cannot prompt for a passphrase")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return pass, nil
+}
+
+// resolveSecret decides where a secret flag's value comes from, in order:
+// filePath (scriptable, never prompts), then an interactive prompt when
+// promptSecrets is set or (autoPromptOnEmpty and flagValue is empty and
+// stdin is a TTY), otherwise flagValue as-is. confirm re-prompts once and
+// errors on mismatch; it only makes sense when a prompt actually happens.
+func resolveSecret(fs afero.Fs, label, flagValue, filePath string, promptSecrets, autoPromptOnEmpty, confirm bool) (string, error) {
+	if filePath != "" {
+		b, err := afero.ReadFile(fs, filePath)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !promptSecrets {
+		if flagValue != "" {
+			return flagValue, nil
+		}
+		if !autoPromptOnEmpty || !term.IsTerminal(int(os.Stdin.Fd())) {
+			return flagValue, nil
+		}
+	}
+	val, err := readPassphrase(label + ": ")
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := readPassphrase("confirm " + label + ": ")
+		if err != nil {
+			return "", err
+		}
+		if string(val) != string(again) {
+			return "", fmt.Errorf("%s did not match confirmation", label)
+		}
+	}
+	return string(val), nil
+}
@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// scrypt parameters for deriving the secretbox key from a passphrase.
+const (
+	scryptN  = 1 << 15
+	scryptR  = 8
+	scryptP  = 1
+	keySize  = 32
+	saltSize = 16
+)
+
+// fileEntry is a single encrypted secret as persisted on disk.
+type fileEntry struct {
+	Nonce      [24]byte
+	Ciphertext []byte
+}
+
+// fileStoreData is the full on-disk layout of a file-backed store: one
+// passphrase salt shared by every entry, plus the entries themselves.
+type fileStoreData struct {
+	Salt    []byte
+	Entries map[string]fileEntry
+}
+
+// fileStore encrypts secrets at rest using a passphrase-derived key
+// (scrypt) and NaCl secretbox, keeping them in a single file.
+type fileStore struct {
+	fs   afero.Fs
+	path string
+	key  [32]byte
+}
+
+// NewFileStore opens (creating if necessary) an encrypted secrets file at
+// path, deriving its key from passphrase. The salt is generated once and
+// persisted alongside the entries so the same passphrase always derives
+// the same key.
+func NewFileStore(fs afero.Fs, path string, passphrase []byte) (Store, error) {
+	data, err := loadFileStoreData(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Salt) == 0 {
+		data.Salt = make([]byte, saltSize)
+		if _, err := rand.Read(data.Salt); err != nil {
+			return nil, err
+		}
+		if err := saveFileStoreData(fs, path, data); err != nil {
+			return nil, err
+		}
+	}
+	derived, err := scrypt.Key(passphrase, data.Salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStore{fs: fs, path: path}
+	copy(s.key[:], derived)
+	return s, nil
+}
+
+func (s *fileStore) Get(name string) ([]byte, error) {
+	data, err := loadFileStoreData(s.fs, s.path)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := data.Entries[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	plain, ok := secretbox.Open(nil, entry.Ciphertext, &entry.Nonce, &s.key)
+	if !ok {
+		return nil, errors.New("secrets: failed to decrypt entry, wrong passphrase?")
+	}
+	return plain, nil
+}
+
+func (s *fileStore) Set(name string, val []byte) error {
+	data, err := loadFileStoreData(s.fs, s.path)
+	if err != nil {
+		return err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	if data.Entries == nil {
+		data.Entries = make(map[string]fileEntry)
+	}
+	data.Entries[name] = fileEntry{
+		Nonce:      nonce,
+		Ciphertext: secretbox.Seal(nil, val, &nonce, &s.key),
+	}
+	return saveFileStoreData(s.fs, s.path, data)
+}
+
+func (s *fileStore) Delete(name string) error {
+	data, err := loadFileStoreData(s.fs, s.path)
+	if err != nil {
+		return err
+	}
+	delete(data.Entries, name)
+	return saveFileStoreData(s.fs, s.path, data)
+}
+
+func loadFileStoreData(fs afero.Fs, path string) (fileStoreData, error) {
+	f, err := fs.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fileStoreData{Entries: make(map[string]fileEntry)}, nil
+	case err != nil:
+		return fileStoreData{}, err
+	}
+	defer func() { _ = f.Close() }()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return fileStoreData{}, err
+	}
+	var data fileStoreData
+	if err := yaml.Unmarshal(b, &data); err != nil {
+		return fileStoreData{}, err
+	}
+	if data.Entries == nil {
+		data.Entries = make(map[string]fileEntry)
+	}
+	return data, nil
+}
+
+func saveFileStoreData(fs afero.Fs, path string, data fileStoreData) error {
+	if err := fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	enc := yaml.NewEncoder(f)
+	if err := enc.Encode(&data); err != nil {
+		return err
+	}
+	return enc.Close()
+}
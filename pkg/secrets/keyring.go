@@ -0,0 +1,44 @@
+package secrets
+
+import (
+	"errors"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is the service name under which all reticule secrets are
+// filed in the OS keyring.
+const keyringService = "reticule"
+
+// keyringStore stores secrets in the OS-native keyring (macOS Keychain,
+// Secret Service on Linux, Windows Credential Manager, ...) via
+// github.com/zalando/go-keyring.
+type keyringStore struct{}
+
+// NewKeyringStore returns a Store backed by the OS keyring.
+func NewKeyringStore() Store {
+	return &keyringStore{}
+}
+
+func (k *keyringStore) Get(name string) ([]byte, error) {
+	v, err := keyring.Get(keyringService, name)
+	if err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+func (k *keyringStore) Set(name string, val []byte) error {
+	return keyring.Set(keyringService, name, string(val))
+}
+
+func (k *keyringStore) Delete(name string) error {
+	err := keyring.Delete(keyringService, name)
+	if err != nil && errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
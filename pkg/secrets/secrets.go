@@ -0,0 +1,18 @@
+// Package secrets provides pluggable storage for sensitive values (API
+// keys, passphrases, pre-shared keys) so callers never need to hold
+// plaintext secrets in a config file on disk.
+package secrets
+
+import "errors"
+
+// ErrNotFound is returned by Store.Get when name has no stored value.
+var ErrNotFound = errors.New("secrets: not found")
+
+// Store persists and retrieves secret material by name. Implementations
+// decide where and how the bytes are actually kept; callers should treat
+// names as opaque refs (e.g. "reticule/<profile>/coinbase").
+type Store interface {
+	Get(name string) ([]byte, error)
+	Set(name string, val []byte) error
+	Delete(name string) error
+}